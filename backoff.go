@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff calcule un délai d'attente exponentiel avec jitter complet,
+// plafonné à Cap, pour espacer les tentatives après un échec.
+type Backoff struct {
+	Base    time.Duration
+	Cap     time.Duration
+	attempt int
+}
+
+// NewBackoff crée un Backoff dont le délai de base double à chaque tentative
+// jusqu'à cap.
+func NewBackoff(base, cap time.Duration) *Backoff {
+	return &Backoff{Base: base, Cap: cap}
+}
+
+// Next renvoie le délai à attendre avant la prochaine tentative et incrémente
+// le compteur d'essais. Jitter complet: délai = rand[0, min(cap, base*2^attempt)).
+func (b *Backoff) Next() time.Duration {
+	d := b.Base << b.attempt
+	if b.attempt > 62 || d <= 0 || d > b.Cap {
+		d = b.Cap
+	}
+	b.attempt++
+
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Reset remet le compteur d'essais à zéro, après une tentative réussie.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// retryAfterDelay parse l'en-tête Retry-After (delta-seconds ou date HTTP) et
+// renvoie la durée à attendre. ok vaut false si l'en-tête est absent ou
+// invalide.
+func retryAfterDelay(h http.Header) (d time.Duration, ok bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
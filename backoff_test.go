@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffNextIsBounded(t *testing.T) {
+	b := NewBackoff(time.Second, 10*time.Second)
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := b.Next()
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, 10*time.Second, "backoff should never exceed the cap")
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := NewBackoff(time.Second, time.Minute)
+	b.Next()
+	b.Next()
+	b.Reset()
+	assert.Equal(t, 0, b.attempt)
+}
+
+func TestRetryAfterDeltaSeconds(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "5")
+
+	d, ok := retryAfterDelay(h)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+}
+
+func TestRetryAfterHTTPDate(t *testing.T) {
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	h := http.Header{}
+	h.Set("Retry-After", future)
+
+	d, ok := retryAfterDelay(h)
+	assert.True(t, ok)
+	assert.InDelta(t, 10*time.Second, d, float64(2*time.Second))
+}
+
+func TestRetryAfterMissing(t *testing.T) {
+	_, ok := retryAfterDelay(http.Header{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config regroupe tous les réglages de l'indexeur. Les valeurs par défaut
+// peuvent être surchargées par flag, puis par variable d'environnement
+// (Telegraf-style), pour rester scriptable depuis un orchestrateur sans
+// changer la ligne de commande.
+type Config struct {
+	ListenAddr     string
+	TzktURL        string
+	WSURL          string
+	PollInterval   time.Duration
+	StartTimestamp string
+	Store          string
+	DBPath         string
+	DBMaxOpenConns int
+	DBMaxIdleConns int
+	FromLevel      int
+	BatchSize      int
+	Source         string
+	PrintConfig    bool
+}
+
+// loadConfig parse args (typiquement os.Args[1:]) puis applique les
+// variables d'environnement reconnues par-dessus.
+func loadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("tezos-delegation", flag.ContinueOnError)
+
+	listenAddr := fs.String("listen-addr", ":8000", "address to listen on (env LISTEN_ADDR)")
+	tzktURL := fs.String("tzkt-url", "https://api.tzkt.io/v1", "tzkt API base URL (env TZKT_URL)")
+	wsURL := fs.String("ws-url", "wss://api.tzkt.io/v1/ws", "tzkt SignalR websocket URL, used when -source=ws")
+	pollIntervalFlag := fs.Duration("poll-interval", defaultPollInterval, "interval between successful fetch cycles (env POLL_INTERVAL)")
+	startTimestamp := fs.String("start-timestamp", defaultStartTimestamp, "RFC3339 timestamp the store reports as its latest while empty (env START_TIMESTAMP); the level-based fetch does not use it")
+	storeFlag := fs.String("store", "sqlite", "storage backend to use: sqlite or postgres")
+	dbPath := fs.String("db", "./delegations.db", "SQLite file path or PostgreSQL connection string (env DB_PATH, or DATABASE_URL)")
+	maxOpenConns := fs.Int("db-max-open-conns", 25, "maximum number of open DB connections (0 = driver default)")
+	maxIdleConns := fs.Int("db-max-idle-conns", 5, "maximum number of idle DB connections (0 = driver default)")
+	fromLevel := fs.Int("from-level", 0, "only fetch delegations above this block level; applies while the store's highest level is lower")
+	batchSize := fs.Int("batch-size", defaultBatchSize, "maximum number of delegations fetched per tzkt request")
+	sourceFlag := fs.String("source", "poll", "delegation source: poll (HTTP) or ws (tzkt SignalR websocket)")
+	printConfigFlag := fs.Bool("print-config", false, "print the effective configuration as JSON and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{
+		ListenAddr:     *listenAddr,
+		TzktURL:        strings.TrimRight(*tzktURL, "/"),
+		WSURL:          *wsURL,
+		PollInterval:   *pollIntervalFlag,
+		StartTimestamp: *startTimestamp,
+		Store:          *storeFlag,
+		DBPath:         *dbPath,
+		DBMaxOpenConns: *maxOpenConns,
+		DBMaxIdleConns: *maxIdleConns,
+		FromLevel:      *fromLevel,
+		BatchSize:      *batchSize,
+		Source:         *sourceFlag,
+		PrintConfig:    *printConfigFlag,
+	}
+
+	if v := os.Getenv("LISTEN_ADDR"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := os.Getenv("TZKT_URL"); v != "" {
+		cfg.TzktURL = strings.TrimRight(v, "/")
+	}
+	if v := os.Getenv("POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid POLL_INTERVAL %q: %w", v, err)
+		}
+		cfg.PollInterval = d
+	}
+	if v := os.Getenv("START_TIMESTAMP"); v != "" {
+		cfg.StartTimestamp = v
+	}
+	if v := os.Getenv("DB_PATH"); v != "" {
+		cfg.DBPath = v
+	}
+
+	driver, dsn := storeConfigFromFlags(cfg.Store, cfg.DBPath)
+	cfg.Store, cfg.DBPath = driver, dsn
+
+	return cfg, nil
+}
+
+// printEffectiveConfig affiche la configuration résolue en JSON, pour que
+// les opérateurs puissent vérifier les réglages avant de lancer l'indexeur.
+func printEffectiveConfig(cfg Config) error {
+	encoded, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(encoded))
+	return nil
+}
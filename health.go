@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxConsecutiveFailures est le nombre d'échecs consécutifs de fetch à partir
+// duquel le fetcher est considéré en panne pour /readyz, même s'il continue
+// de réessayer en arrière-plan.
+const maxConsecutiveFailures = 10
+
+// fetchHealth suit l'état de santé de la boucle de fetch tzkt.
+type fetchHealth struct {
+	mu          sync.RWMutex
+	failures    int
+	lastErr     error
+	lastSuccess time.Time
+}
+
+// recordSuccess remet à zéro le compteur d'échecs consécutifs et horodate le
+// succès.
+func (h *fetchHealth) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	h.lastErr = nil
+	h.lastSuccess = time.Now()
+	lastSuccessfulFetchTimestamp.Set(float64(h.lastSuccess.Unix()))
+}
+
+// recordFailure incrémente le compteur d'échecs consécutifs et retient la
+// dernière erreur rencontrée.
+func (h *fetchHealth) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastErr = err
+}
+
+// Status renvoie si le fetcher est considéré en bonne santé, le nombre
+// d'échecs consécutifs et la dernière erreur le cas échéant.
+func (h *fetchHealth) Status() (healthy bool, failures int, lastErr error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.failures < maxConsecutiveFailures, h.failures, h.lastErr
+}
+
+// LastSuccess renvoie l'horodatage du dernier fetch réussi (zero value si
+// aucun n'a encore eu lieu).
+func (h *fetchHealth) LastSuccess() time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastSuccess
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchHealthTransitions(t *testing.T) {
+	h := &fetchHealth{}
+
+	healthy, failures, lastErr := h.Status()
+	assert.True(t, healthy)
+	assert.Zero(t, failures)
+	assert.NoError(t, lastErr)
+	assert.True(t, h.LastSuccess().IsZero())
+
+	errBoom := errors.New("boom")
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		h.recordFailure(errBoom)
+	}
+	healthy, failures, lastErr = h.Status()
+	assert.False(t, healthy, "should be unhealthy after maxConsecutiveFailures")
+	assert.Equal(t, maxConsecutiveFailures, failures)
+	assert.Equal(t, errBoom, lastErr)
+
+	h.recordSuccess()
+	healthy, failures, lastErr = h.Status()
+	assert.True(t, healthy, "a success should reset the failure streak")
+	assert.Zero(t, failures)
+	assert.NoError(t, lastErr)
+	assert.False(t, h.LastSuccess().IsZero())
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,12 +9,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Sender struct pour contenir les informations sur l'expéditeur
@@ -31,144 +32,323 @@ type Delegation struct {
 	Level     int    `json:"level"`
 }
 
-// initDB initialise la base de données et ajoute des index pour améliorer les performances
-func initDB() (*sql.DB, error) {
-	dbPath := "./delegations.db"
-	database, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, err
-	}
+// storeConfigFromFlags détermine le driver et le DSN à utiliser, en
+// laissant DATABASE_URL (si présent) l'emporter sur les flags -store/-db.
+func storeConfigFromFlags(storeFlag, dbFlag string) (driver, dsn string) {
+	driver, dsn = storeFlag, dbFlag
 
-	statement, err := database.Prepare(`CREATE TABLE IF NOT EXISTS delegations (
-        timestamp TEXT,
-        amount INT64,
-        delegator TEXT,
-        level INT,
-        PRIMARY KEY (timestamp, delegator))`)
-	if err != nil {
-		return nil, err
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return nil, err
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		dsn = url
+		if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+			driver = "postgres"
+		}
 	}
 
-	// Ajouter des index pour améliorer les performances des requêtes
-	_, err = database.Exec(`CREATE INDEX IF NOT EXISTS idx_timestamp ON delegations (timestamp)`)
-	if err != nil {
-		return nil, err
-	}
+	return driver, dsn
+}
 
-	_, err = database.Exec(`CREATE INDEX IF NOT EXISTS idx_delegator ON delegations (delegator)`)
-	if err != nil {
-		return nil, err
-	}
+// defaultPollInterval est l'attente entre deux cycles de fetch une fois
+// rattrapé, sauf override via -poll-interval/POLL_INTERVAL.
+const defaultPollInterval = 30 * time.Second
 
-	return database, nil
-}
+// readyzPollMultiplier fixe la fenêtre de fraîcheur tolérée par /readyz pour
+// le dernier fetch réussi, en multiples de l'intervalle de poll configuré.
+const readyzPollMultiplier = 3
 
-// fetchDelegations récupère les délégations et les stocke dans la base de données
-func fetchDelegations(db *sql.DB, wg *sync.WaitGroup, stopChan chan struct{}) {
+// tzktDelegationsURL est l'endpoint tzkt interrogé pour les délégations.
+// Variable (plutôt que const) pour pouvoir être redirigée vers un serveur de
+// test.
+var tzktDelegationsURL = "https://api.tzkt.io/v1/operations/delegations"
+
+// defaultBatchSize est la taille de page utilisée pour le rattrapage, sauf
+// override via -batch-size.
+const defaultBatchSize = 10000
+
+// fetchDelegations rattrape le retard par pages successives keyed sur level
+// (cf. fetchDelegationsPage), puis attend pollInterval avant de revérifier.
+// Les échecs sont espacés par un backoff exponentiel avec jitter, remplacé
+// par le délai du Retry-After si tzkt en renvoie un.
+func fetchDelegations(store Store, health *fetchHealth, fromLevel, batchSize int, pollInterval time.Duration, wg *sync.WaitGroup, stopChan chan struct{}) {
 	defer wg.Done()
+	backoff := NewBackoff(time.Second, 5*time.Minute)
+
 	for {
 		select {
 		case <-stopChan:
 			fmt.Println("FetchDelegations stopping...")
 			return
 		default:
-			lastTimestamp := getLastTimestamp(db)
-			url := fmt.Sprintf("https://api.tzkt.io/v1/operations/delegations?timestamp.gt=%s&limit=10000", lastTimestamp)
-			resp, err := http.Get(url)
-			if err != nil {
-				log.Println("Error fetching data:", err)
-				time.Sleep(30 * time.Second)
-				continue
-			}
+		}
 
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Println("Error reading body:", err)
-				resp.Body.Close()
-				time.Sleep(30 * time.Second)
-				continue
-			}
-			resp.Body.Close()
+		lastLevel, err := store.LastLevel(context.Background())
+		if err != nil {
+			log.Println("Error getting last level:", err)
+			lastLevel = fromLevel
+		}
+		if lastLevel < fromLevel {
+			lastLevel = fromLevel
+		}
 
-			var fetched []struct {
-				Timestamp string `json:"timestamp"`
-				Amount    int64  `json:"amount"`
-				Sender    Sender `json:"sender"`
-				Level     int    `json:"level"`
-			}
-			if err := json.Unmarshal(body, &fetched); err != nil {
-				log.Println("Error unmarshaling:", err)
-				time.Sleep(30 * time.Second)
-				continue
-			}
+		caughtUp, ok := catchUp(store, health, backoff, &lastLevel, batchSize, stopChan)
+		if !ok {
+			return
+		}
+		if !caughtUp {
+			// A page fetch failed; the backoff wait already ran, retry the
+			// catch-up loop from the last successfully stored level.
+			continue
+		}
 
-			for _, f := range fetched {
-				fmt.Printf("Fetched delegation - Timestamp: %s, Amount: %d, Delegator: %s, Level: %d\n", f.Timestamp, f.Amount, f.Sender.Address, f.Level)
-				_, err := db.Exec("INSERT OR IGNORE INTO delegations (timestamp, amount, delegator, level) VALUES (?, ?, ?, ?)",
-					f.Timestamp, f.Amount, f.Sender.Address, f.Level)
-				if err != nil {
-					log.Println("Database insert error:", err)
-				}
+		health.recordSuccess()
+		backoff.Reset()
+
+		if !sleepOrStop(pollInterval, stopChan) {
+			return
+		}
+	}
+}
+
+// catchUp appelle fetchDelegationsPage en boucle jusqu'à ce qu'une page
+// renvoie moins de batchSize lignes (signe que le rattrapage est terminé).
+// Elle renvoie caughtUp=false en cas d'échec (après avoir attendu le
+// backoff), et ok=false si l'arrêt a été demandé pendant l'attente.
+func catchUp(store Store, health *fetchHealth, backoff *Backoff, lastLevel *int, batchSize int, stopChan chan struct{}) (caughtUp, ok bool) {
+	for {
+		select {
+		case <-stopChan:
+			fmt.Println("FetchDelegations stopping...")
+			return false, false
+		default:
+		}
+
+		count, maxLevel, retryAfter, err := fetchDelegationsPage(context.Background(), store, *lastLevel, batchSize)
+		if err != nil {
+			log.Println("Error fetching delegations:", err)
+			health.recordFailure(err)
+
+			wait := retryAfter
+			if wait == 0 {
+				wait = backoff.Next()
 			}
+			return false, sleepOrStop(wait, stopChan)
+		}
 
-			time.Sleep(30 * time.Second)
+		if count > 0 {
+			*lastLevel = maxLevel
+		}
+		if count < batchSize {
+			return true, true
 		}
 	}
 }
 
-// getLastTimestamp récupère la dernière timestamp enregistrée
-func getLastTimestamp(db *sql.DB) string {
-	var lastTimestamp string
-	row := db.QueryRow("SELECT timestamp FROM delegations ORDER BY timestamp DESC LIMIT 1")
-	switch err := row.Scan(&lastTimestamp); err {
-	case sql.ErrNoRows:
-		return "2023-08-23T00:00:00Z"
-	case nil:
-		return lastTimestamp
-	default:
-		log.Println("Error getting last timestamp:", err)
-		return "2023-08-23T00:00:00Z"
+// sleepOrStop attend d en surveillant stopChan ; renvoie false si l'arrêt a
+// été demandé pendant l'attente.
+func sleepOrStop(d time.Duration, stopChan chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stopChan:
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
-// getDelegations récupère les délégations stockées et les renvoie sous forme de JSON
-func getDelegations(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	year := r.URL.Query().Get("year")
-	var rows *sql.Rows
-	var err error
+// fetchDelegationsPage récupère au plus limit délégations de niveau
+// strictement supérieur à sinceLevel, triées par level croissant, et les
+// stocke. Elle renvoie le nombre de lignes reçues et le plus haut level
+// observé, pour que l'appelant avance son curseur même si certaines lignes
+// étaient déjà en base. Elle renvoie un Retry-After le cas échéant
+// (réponse 429/503), que l'appelant peut utiliser à la place de son propre
+// backoff.
+func fetchDelegationsPage(ctx context.Context, store Store, sinceLevel, limit int) (count, lastLevel int, retryAfter time.Duration, err error) {
+	lastLevel = sinceLevel
+	url := fmt.Sprintf("%s?level.gt=%d&limit=%d&sort.asc=level", tzktDelegationsURL, sinceLevel, limit)
 
-	if year != "" {
-		rows, err = db.Query("SELECT timestamp, amount, delegator, level FROM delegations WHERE strftime('%Y', timestamp) = ? ORDER BY timestamp DESC", year)
-	} else {
-		rows, err = db.Query("SELECT timestamp, amount, delegator, level FROM delegations ORDER BY timestamp DESC")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, lastLevel, 0, err
 	}
 
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	tzktRequestDurationSeconds.Observe(time.Since(start).Seconds())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database query error: %v", err), http.StatusInternalServerError)
-		return
+		tzktRequestErrorsTotal.WithLabelValues("network").Inc()
+		return 0, lastLevel, 0, fmt.Errorf("fetching delegations: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		tzktRequestErrorsTotal.WithLabelValues("status").Inc()
+		if d, ok := retryAfterDelay(resp.Header); ok {
+			retryAfter = d
+		}
+		return 0, lastLevel, retryAfter, fmt.Errorf("tzkt returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		tzktRequestErrorsTotal.WithLabelValues("status").Inc()
+		return 0, lastLevel, 0, fmt.Errorf("tzkt returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tzktRequestErrorsTotal.WithLabelValues("decode").Inc()
+		return 0, lastLevel, 0, fmt.Errorf("reading response body: %w", err)
+	}
+
+	var fetched []struct {
+		Timestamp string `json:"timestamp"`
+		Amount    int64  `json:"amount"`
+		Sender    Sender `json:"sender"`
+		Level     int    `json:"level"`
+	}
+	if err := json.Unmarshal(body, &fetched); err != nil {
+		tzktRequestErrorsTotal.WithLabelValues("decode").Inc()
+		return 0, lastLevel, 0, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	delegations := make([]Delegation, 0, len(fetched))
+	for _, f := range fetched {
+		fmt.Printf("Fetched delegation - Timestamp: %s, Amount: %d, Delegator: %s, Level: %d\n", f.Timestamp, f.Amount, f.Sender.Address, f.Level)
+		delegations = append(delegations, Delegation{
+			Timestamp: f.Timestamp,
+			Amount:    f.Amount,
+			Delegator: f.Sender.Address,
+			Level:     f.Level,
+		})
+		if f.Level > lastLevel {
+			lastLevel = f.Level
+		}
+	}
+
+	inserted, err := store.InsertDelegations(ctx, delegations)
+	if err != nil {
+		return 0, sinceLevel, 0, fmt.Errorf("inserting delegations: %w", err)
+	}
+
+	delegationsFetchedTotal.Add(float64(len(fetched)))
+	delegationsInsertedTotal.Add(float64(inserted))
+
+	return len(fetched), lastLevel, 0, nil
+}
+
+// getDelegations récupère les délégations stockées et les renvoie sous forme
+// de JSON. Au-delà de year, elle accepte from/to (RFC3339), delegator
+// (égalité exacte), min_amount, limit et cursor (keyset, cf. encodeCursor) et
+// renvoie un next_cursor quand d'autres lignes restent à paginer.
+func getDelegations(w http.ResponseWriter, r *http.Request, store Store) {
+	q := r.URL.Query()
+
+	filter := DelegationFilter{
+		Year:      q.Get("year"),
+		From:      q.Get("from"),
+		To:        q.Get("to"),
+		Delegator: q.Get("delegator"),
+	}
+
+	if v := q.Get("min_amount"); v != "" {
+		minAmount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid min_amount %q", v), http.StatusBadRequest)
+			return
+		}
+		filter.MinAmount = minAmount
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
 	}
-	defer rows.Close()
 
-	var delegations []Delegation
-	for rows.Next() {
-		var d Delegation
-		if err := rows.Scan(&d.Timestamp, &d.Amount, &d.Delegator, &d.Level); err != nil {
-			http.Error(w, fmt.Sprintf("Database scan error: %v", err), http.StatusInternalServerError)
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		delegations = append(delegations, d)
+		filter.Cursor = &cursor
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
 	}
+	filter.Limit = limit + 1 // une ligne de plus pour détecter s'il reste une page
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Rows iteration error: %v", err), http.StatusInternalServerError)
+	delegations, err := store.Query(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database query error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, http.StatusOK, map[string][]Delegation{"data": delegations})
+	response := map[string]interface{}{}
+	if len(delegations) > limit {
+		last := delegations[limit-1]
+		response["next_cursor"] = encodeCursor(DelegationCursor{Timestamp: last.Timestamp, Delegator: last.Delegator})
+		delegations = delegations[:limit]
+	}
+	response["data"] = delegations
+
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// newRouter câble les routes HTTP exposées par le service. pollInterval sert
+// à /readyz pour juger de la fraîcheur du dernier fetch réussi.
+func newRouter(store Store, health *fetchHealth, pollInterval time.Duration) *mux.Router {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/xtz/delegations", func(w http.ResponseWriter, r *http.Request) {
+		getDelegations(w, r, store)
+	}).Methods("GET")
+
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		sendJSONResponse(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+	}).Methods("GET")
+
+	r.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		getReadyz(w, r, store, health, pollInterval)
+	}).Methods("GET")
+
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	return r
+}
+
+// getReadyz renvoie 200 si la base est joignable, qu'un fetch a réussi il
+// y a moins de readyzPollMultiplier*pollInterval et que le nombre d'échecs
+// consécutifs reste sous maxConsecutiveFailures, 503 sinon.
+func getReadyz(w http.ResponseWriter, r *http.Request, store Store, health *fetchHealth, pollInterval time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	var reasons []string
+
+	if err := store.Ping(ctx); err != nil {
+		reasons = append(reasons, fmt.Sprintf("database unreachable: %v", err))
+	}
+
+	healthy, failures, lastErr := health.Status()
+	if !healthy {
+		reasons = append(reasons, fmt.Sprintf("%d consecutive fetch failures: %v", failures, lastErr))
+	}
+
+	maxFetchAge := readyzPollMultiplier * pollInterval
+	if last := health.LastSuccess(); last.IsZero() || time.Since(last) > maxFetchAge {
+		reasons = append(reasons, fmt.Sprintf("no successful fetch in the last %s", maxFetchAge))
+	}
+
+	ready := len(reasons) == 0
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	sendJSONResponse(w, status, map[string]interface{}{"ready": ready, "reasons": reasons})
 }
 
 // sendJSONResponse envoie une réponse JSON uniforme
@@ -181,24 +361,46 @@ func sendJSONResponse(w http.ResponseWriter, status int, payload interface{}) {
 }
 
 func main() {
-	db, err := initDB()
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	defer db.Close()
 
-	r := mux.NewRouter()
-	r.HandleFunc("/xtz/delegations", func(w http.ResponseWriter, r *http.Request) {
-		getDelegations(w, r, db)
-	}).Methods("GET")
+	if cfg.PrintConfig {
+		if err := printEffectiveConfig(cfg); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	tzktDelegationsURL = cfg.TzktURL + "/operations/delegations"
+
+	store, err := NewStore(cfg.Store, cfg.DBPath, PoolConfig{MaxOpenConns: cfg.DBMaxOpenConns, MaxIdleConns: cfg.DBMaxIdleConns}, cfg.StartTimestamp)
+	if err != nil {
+		log.Fatalf("Failed to initialize store: %v", err)
+	}
+	defer store.Close()
+
+	health := &fetchHealth{}
+	r := newRouter(store, health, cfg.PollInterval)
+
+	var source Source
+	switch cfg.Source {
+	case "", "poll":
+		source = &pollSource{FromLevel: cfg.FromLevel, BatchSize: cfg.BatchSize, PollInterval: cfg.PollInterval}
+	case "ws":
+		source = &wsSource{URL: cfg.WSURL, FromLevel: cfg.FromLevel, BatchSize: cfg.BatchSize}
+	default:
+		log.Fatalf("unknown -source %q (expected poll or ws)", cfg.Source)
+	}
 
 	var wg sync.WaitGroup
 	stopChan := make(chan struct{})
 	wg.Add(1)
-	go fetchDelegations(db, &wg, stopChan)
+	go source.Run(store, health, &wg, stopChan)
 
 	srv := &http.Server{
-		Addr:    ":8000",
+		Addr:    cfg.ListenAddr,
 		Handler: r,
 	}
 
@@ -220,7 +422,7 @@ func main() {
 		fmt.Println("Server stopped")
 	}()
 
-	fmt.Println("Server is running on port 8000")
+	fmt.Printf("Server is running on %s\n", cfg.ListenAddr)
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("ListenAndServe(): %s", err)
 	}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Métriques Prometheus de la boucle de fetch tzkt. Les types prometheus sont
+// déjà thread-safe (atomiques en interne), donc utilisables directement
+// depuis le chemin chaud sans mutex additionnel.
+var (
+	delegationsFetchedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "delegations_fetched_total",
+		Help: "Total number of delegations fetched from tzkt.",
+	})
+
+	delegationsInsertedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "delegations_inserted_total",
+		Help: "Total number of delegations inserted into the store.",
+	})
+
+	tzktRequestErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tzkt_request_errors_total",
+		Help: "Total number of failed tzkt requests, by error kind.",
+	}, []string{"kind"})
+
+	tzktRequestDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tzkt_request_duration_seconds",
+		Help:    "Duration of tzkt HTTP requests in seconds.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	lastSuccessfulFetchTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "last_successful_fetch_timestamp",
+		Help: "Unix timestamp of the last successful tzkt fetch.",
+	})
+)
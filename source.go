@@ -0,0 +1,9 @@
+package main
+
+import "sync"
+
+// Source alimente le store en délégations en continu, jusqu'à la fermeture
+// de stopChan. Run doit appeler wg.Done() avant de retourner.
+type Source interface {
+	Run(store Store, health *fetchHealth, wg *sync.WaitGroup, stopChan chan struct{})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pollSource implémente Source par polling HTTP REST (cf. fetchDelegations).
+// C'est la source par défaut: elle ne requiert aucune dépendance WebSocket
+// et fonctionne donc sans changement en CI/tests.
+type pollSource struct {
+	FromLevel    int
+	BatchSize    int
+	PollInterval time.Duration
+}
+
+func (s *pollSource) Run(store Store, health *fetchHealth, wg *sync.WaitGroup, stopChan chan struct{}) {
+	pollInterval := s.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	fetchDelegations(store, health, s.FromLevel, s.BatchSize, pollInterval, wg, stopChan)
+}
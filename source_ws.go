@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// signalRRecordSeparator délimite les messages du protocole SignalR encodés
+// en JSON (une frame websocket peut en contenir un ou plusieurs).
+const signalRRecordSeparator = '\x1e'
+
+// signalRMessage est une invocation SignalR générique. tzkt envoie les
+// mises à jour de souscription sous target="operations".
+type signalRMessage struct {
+	Type      int                    `json:"type"`
+	Target    string                 `json:"target"`
+	Arguments []signalROperationsArg `json:"arguments"`
+}
+
+// signalROperationsArg porte un lot d'opérations pour un type donné.
+// State vaut 0 pour le message d'initialisation de la souscription (sans
+// données) et 1 pour les lots de données qui suivent.
+type signalROperationsArg struct {
+	Type  string          `json:"type"`
+	State int             `json:"state"`
+	Data  []tzktOperation `json:"data"`
+}
+
+// tzktOperation est le sous-ensemble des champs d'une opération de
+// délégation qui nous intéresse, qu'elle arrive par REST ou par websocket.
+type tzktOperation struct {
+	Timestamp string `json:"timestamp"`
+	Amount    int64  `json:"amount"`
+	Sender    Sender `json:"sender"`
+	Level     int    `json:"level"`
+}
+
+// wsSource implémente Source via le hub SignalR de tzkt
+// (wss://api.tzkt.io/v1/ws) : un rattrapage REST comble l'écart avec le
+// dernier niveau en base avant de basculer sur le flux temps réel ; en cas
+// de coupure, elle se reconnecte avec backoff puis rejoue le rattrapage.
+type wsSource struct {
+	URL       string
+	FromLevel int
+	BatchSize int
+}
+
+func (s *wsSource) Run(store Store, health *fetchHealth, wg *sync.WaitGroup, stopChan chan struct{}) {
+	defer wg.Done()
+	backoff := NewBackoff(time.Second, 5*time.Minute)
+
+	for {
+		select {
+		case <-stopChan:
+			fmt.Println("FetchDelegations stopping...")
+			return
+		default:
+		}
+
+		if err := s.runOnce(store, health, stopChan); err != nil {
+			log.Println("tzkt websocket error:", err)
+			health.recordFailure(err)
+			if !sleepOrStop(backoff.Next(), stopChan) {
+				return
+			}
+			continue
+		}
+
+		backoff.Reset()
+	}
+}
+
+// runOnce rattrape le retard via REST, se connecte au hub SignalR, puis
+// relaie les délégations reçues vers le store jusqu'à déconnexion ou arrêt
+// demandé (auquel cas elle renvoie nil).
+func (s *wsSource) runOnce(store Store, health *fetchHealth, stopChan chan struct{}) error {
+	lastLevel, err := store.LastLevel(context.Background())
+	if err != nil {
+		return fmt.Errorf("reading last level: %w", err)
+	}
+	if lastLevel < s.FromLevel {
+		lastLevel = s.FromLevel
+	}
+
+	catchUpBackoff := NewBackoff(time.Second, 5*time.Minute)
+	caughtUp, ok := catchUp(store, health, catchUpBackoff, &lastLevel, s.BatchSize, stopChan)
+	if !ok {
+		return nil
+	}
+	if !caughtUp {
+		return fmt.Errorf("REST catch-up failed before switching to the websocket stream")
+	}
+	health.recordSuccess()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.URL, nil)
+	if err != nil {
+		return fmt.Errorf("dialing tzkt websocket: %w", err)
+	}
+	defer conn.Close()
+
+	if err := signalRHandshake(conn); err != nil {
+		return fmt.Errorf("signalr handshake: %w", err)
+	}
+	if err := signalRSubscribeToDelegations(conn); err != nil {
+		return fmt.Errorf("subscribing to delegations: %w", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-stopChan:
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+	defer close(stopped)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-stopChan:
+				return nil
+			default:
+				return fmt.Errorf("reading websocket message: %w", err)
+			}
+		}
+
+		if err := s.handleFrame(store, health, data); err != nil {
+			return err
+		}
+	}
+}
+
+// handleFrame décode une frame websocket (un ou plusieurs messages SignalR
+// séparés par signalRRecordSeparator) et stocke les délégations reçues.
+//
+// Tout message SignalR décodé (y compris les pings de keep-alive du hub et le
+// message state=0 d'initialisation) marque la connexion comme saine ; les
+// messages vides ou mal formés sont ignorés sans rien enregistrer. Contrairement
+// au poller, un flux temps réel peut rester silencieux côté données pendant
+// de longues périodes (chaîne calme) sans que la connexion ne soit en
+// défaut, donc la fraîcheur de /readyz ne doit pas dépendre de l'arrivée de
+// nouvelles délégations.
+func (s *wsSource) handleFrame(store Store, health *fetchHealth, data []byte) error {
+	for _, frame := range bytes.Split(bytes.TrimSuffix(data, []byte{signalRRecordSeparator}), []byte{signalRRecordSeparator}) {
+		if len(bytes.TrimSpace(frame)) == 0 {
+			continue
+		}
+
+		var msg signalRMessage
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			log.Println("skipping malformed signalr frame:", err)
+			continue
+		}
+
+		health.recordSuccess()
+
+		if msg.Type != 1 || msg.Target != "operations" {
+			continue
+		}
+
+		for _, arg := range msg.Arguments {
+			if arg.Type != "delegation" || arg.State == 0 {
+				// state=0 est l'initialisation de la souscription, sans données.
+				continue
+			}
+
+			delegations := make([]Delegation, 0, len(arg.Data))
+			for _, d := range arg.Data {
+				delegations = append(delegations, Delegation{
+					Timestamp: d.Timestamp,
+					Amount:    d.Amount,
+					Delegator: d.Sender.Address,
+					Level:     d.Level,
+				})
+			}
+
+			inserted, err := store.InsertDelegations(context.Background(), delegations)
+			if err != nil {
+				return fmt.Errorf("inserting delegations: %w", err)
+			}
+
+			delegationsFetchedTotal.Add(float64(len(delegations)))
+			delegationsInsertedTotal.Add(float64(inserted))
+		}
+	}
+
+	return nil
+}
+
+// signalRHandshake négocie le protocole JSON du hub SignalR.
+func signalRHandshake(conn *websocket.Conn) error {
+	handshake := struct {
+		Protocol string `json:"protocol"`
+		Version  int    `json:"version"`
+	}{Protocol: "json", Version: 1}
+
+	if err := writeSignalRMessage(conn, handshake); err != nil {
+		return err
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(bytes.TrimSuffix(data, []byte{signalRRecordSeparator}), &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("signalr handshake rejected: %s", resp.Error)
+	}
+
+	return nil
+}
+
+// signalRSubscribeToDelegations invoque SubscribeToOperations pour ne
+// recevoir que les délégations.
+func signalRSubscribeToDelegations(conn *websocket.Conn) error {
+	invocation := struct {
+		Type      int           `json:"type"`
+		Target    string        `json:"target"`
+		Arguments []interface{} `json:"arguments"`
+	}{
+		Type:      1,
+		Target:    "SubscribeToOperations",
+		Arguments: []interface{}{map[string]string{"types": "delegation"}},
+	}
+
+	return writeSignalRMessage(conn, invocation)
+}
+
+// writeSignalRMessage encode v en JSON et l'envoie suivi du séparateur
+// d'enregistrement attendu par le protocole SignalR.
+func writeSignalRMessage(conn *websocket.Conn, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, signalRRecordSeparator)
+	return conn.WriteMessage(websocket.TextMessage, body)
+}
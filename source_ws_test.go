@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleFrameInsertsDelegationsAndSkipsInitMessage(t *testing.T) {
+	store, err := newSQLiteStore(":memory:", PoolConfig{}, "")
+	assert.NoError(t, err, "Error initializing store")
+	defer store.Close()
+
+	s := &wsSource{}
+	health := &fetchHealth{}
+
+	initFrame := []byte(`{"type":1,"target":"operations","arguments":[{"type":"delegation","state":0,"data":[]}]}` + string(signalRRecordSeparator))
+	assert.NoError(t, s.handleFrame(store, health, initFrame))
+
+	delegations, err := store.Query(context.Background(), DelegationFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 0, "the state=0 init message carries no data")
+
+	dataFrame := []byte(`{"type":1,"target":"operations","arguments":[{"type":"delegation","state":1,"data":[{"timestamp":"2023-01-01T00:00:00Z","amount":1000,"sender":{"address":"tz1WsTestAddress"},"level":123456}]}]}` + string(signalRRecordSeparator))
+	assert.NoError(t, s.handleFrame(store, health, dataFrame))
+
+	delegations, err = store.Query(context.Background(), DelegationFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 1)
+	assert.Equal(t, "tz1WsTestAddress", delegations[0].Delegator)
+
+	healthy, _, _ := health.Status()
+	assert.True(t, healthy)
+	assert.False(t, health.LastSuccess().IsZero(), "a successful message should record a health success")
+}
+
+func TestHandleFrameIgnoresNonOperationsMessages(t *testing.T) {
+	store, err := newSQLiteStore(":memory:", PoolConfig{}, "")
+	assert.NoError(t, err, "Error initializing store")
+	defer store.Close()
+
+	s := &wsSource{}
+	health := &fetchHealth{}
+
+	pingFrame := []byte(`{"type":6}` + string(signalRRecordSeparator))
+	assert.NoError(t, s.handleFrame(store, health, pingFrame))
+
+	delegations, err := store.Query(context.Background(), DelegationFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 0)
+}
+
+// TestHandleFrameRecordsSuccessOnNonDataFrames vérifie qu'une frame sans
+// délégation (ping de keep-alive ou message state=0) marque quand même la
+// connexion comme saine, pour que /readyz ne flappe pas pendant les
+// périodes calmes d'un flux temps réel sans nouvelle délégation.
+func TestHandleFrameRecordsSuccessOnNonDataFrames(t *testing.T) {
+	store, err := newSQLiteStore(":memory:", PoolConfig{}, "")
+	assert.NoError(t, err, "Error initializing store")
+	defer store.Close()
+
+	s := &wsSource{}
+	health := &fetchHealth{}
+
+	pingFrame := []byte(`{"type":6}` + string(signalRRecordSeparator))
+	assert.NoError(t, s.handleFrame(store, health, pingFrame))
+	assert.False(t, health.LastSuccess().IsZero(), "a keep-alive ping should count as a health success")
+
+	health2 := &fetchHealth{}
+	initFrame := []byte(`{"type":1,"target":"operations","arguments":[{"type":"delegation","state":0,"data":[]}]}` + string(signalRRecordSeparator))
+	assert.NoError(t, s.handleFrame(store, health2, initFrame))
+	assert.False(t, health2.LastSuccess().IsZero(), "the state=0 init message should count as a health success")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// defaultQueryLimit borne le nombre de lignes renvoyées par Query quand
+// l'appelant ne fixe pas de limite explicite.
+const defaultQueryLimit = 1000
+
+// DelegationFilter décrit les critères de filtrage appliqués à une requête
+// de lecture des délégations. Les champs laissés à leur valeur zéro ne
+// filtrent pas.
+type DelegationFilter struct {
+	Year      string
+	From      string // RFC3339, borne inférieure incluse sur timestamp
+	To        string // RFC3339, borne supérieure incluse sur timestamp
+	Delegator string // égalité exacte
+	MinAmount int64
+	Limit     int               // defaultQueryLimit si <= 0
+	Cursor    *DelegationCursor // reprise après ce point, en pagination keyset
+}
+
+// DelegationCursor identifie la dernière ligne vue par un appelant qui
+// pagine, sous la forme opaque attendue par Query (tri décroissant sur
+// (timestamp, delegator), qui est la clé primaire de la table).
+type DelegationCursor struct {
+	Timestamp string
+	Delegator string
+}
+
+// encodeCursor encode un DelegationCursor en une chaîne opaque à renvoyer au
+// client (next_cursor).
+func encodeCursor(c DelegationCursor) string {
+	return base64.URLEncoding.EncodeToString([]byte(c.Timestamp + "|" + c.Delegator))
+}
+
+// decodeCursor décode une chaîne produite par encodeCursor.
+func decodeCursor(s string) (DelegationCursor, error) {
+	raw, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return DelegationCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return DelegationCursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	return DelegationCursor{Timestamp: parts[0], Delegator: parts[1]}, nil
+}
+
+// Store abstrait le backend de persistance des délégations, pour permettre
+// de faire cohabiter plusieurs moteurs (SQLite, PostgreSQL, ...) derrière la
+// même API.
+type Store interface {
+	// InsertDelegations insère les délégations fournies, en ignorant les
+	// doublons (même timestamp + delegator), et renvoie le nombre de lignes
+	// effectivement insérées (hors doublons ignorés).
+	InsertDelegations(ctx context.Context, delegations []Delegation) (inserted int, err error)
+	// LastTimestamp renvoie le timestamp de la délégation la plus récente en
+	// base, ou le timestamp de départ configuré s'il n'y a encore aucune
+	// donnée.
+	LastTimestamp(ctx context.Context) (string, error)
+	// LastLevel renvoie le plus grand niveau de bloc (level) enregistré, ou 0
+	// s'il n'y a encore aucune donnée. Utilisé comme curseur de rattrapage par
+	// le fetcher.
+	LastLevel(ctx context.Context) (int, error)
+	// Query renvoie les délégations correspondant au filtre, triées par
+	// (timestamp, delegator) décroissants.
+	Query(ctx context.Context, filter DelegationFilter) ([]Delegation, error)
+	// Close libère les ressources associées au store (pool de connexions...).
+	Close() error
+	// Ping vérifie que le backend est joignable, pour /readyz.
+	Ping(ctx context.Context) error
+}
+
+// defaultStartTimestamp est le point de départ utilisé quand l'opérateur ne
+// configure pas START_TIMESTAMP et que la base est vide.
+const defaultStartTimestamp = "2023-08-23T00:00:00Z"
+
+// PoolConfig regroupe les réglages de pool de connexions partagés par les
+// backends SQL.
+type PoolConfig struct {
+	MaxOpenConns int
+	MaxIdleConns int
+}
+
+// NewStore construit le Store correspondant au driver demandé ("sqlite" ou
+// "postgres"). dsn est soit un chemin de fichier SQLite, soit une URL de
+// connexion PostgreSQL (cf. DATABASE_URL). startTimestamp est la valeur
+// renvoyée par LastTimestamp tant que la base est vide.
+func NewStore(driver, dsn string, pool PoolConfig, startTimestamp string) (Store, error) {
+	switch strings.ToLower(driver) {
+	case "", "sqlite", "sqlite3":
+		return newSQLiteStore(dsn, pool, startTimestamp)
+	case "postgres", "postgresql":
+		return newPostgresStore(dsn, pool, startTimestamp)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", driver)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore implémente Store au-dessus de SQLite, via database/sql.
+type sqliteStore struct {
+	db             *sql.DB
+	startTimestamp string
+}
+
+// newSQLiteStore ouvre (ou crée) la base SQLite à dsn et s'assure que le
+// schéma existe. dsn peut être un chemin de fichier ou ":memory:" pour les
+// tests. startTimestamp est la valeur renvoyée par LastTimestamp tant que la
+// base est vide.
+func newSQLiteStore(dsn string, pool PoolConfig, startTimestamp string) (*sqliteStore, error) {
+	if dsn == "" {
+		dsn = "./delegations.db"
+	}
+	if startTimestamp == "" {
+		startTimestamp = defaultStartTimestamp
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	applyPoolConfig(db, pool)
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS delegations (
+        timestamp TEXT,
+        amount INT64,
+        delegator TEXT,
+        level INT,
+        PRIMARY KEY (timestamp, delegator))`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_timestamp ON delegations (timestamp)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_delegator ON delegations (delegator)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db, startTimestamp: startTimestamp}, nil
+}
+
+func (s *sqliteStore) InsertDelegations(ctx context.Context, delegations []Delegation) (int, error) {
+	var inserted int
+	for _, d := range delegations {
+		res, err := s.db.ExecContext(ctx,
+			"INSERT OR IGNORE INTO delegations (timestamp, amount, delegator, level) VALUES (?, ?, ?, ?)",
+			d.Timestamp, d.Amount, d.Delegator, d.Level)
+		if err != nil {
+			return inserted, fmt.Errorf("insert delegation: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			inserted += int(n)
+		}
+	}
+	return inserted, nil
+}
+
+func (s *sqliteStore) LastTimestamp(ctx context.Context) (string, error) {
+	var lastTimestamp string
+	row := s.db.QueryRowContext(ctx, "SELECT timestamp FROM delegations ORDER BY timestamp DESC LIMIT 1")
+	switch err := row.Scan(&lastTimestamp); err {
+	case sql.ErrNoRows:
+		return s.startTimestamp, nil
+	case nil:
+		return lastTimestamp, nil
+	default:
+		return "", err
+	}
+}
+
+func (s *sqliteStore) LastLevel(ctx context.Context) (int, error) {
+	var level sql.NullInt64
+	row := s.db.QueryRowContext(ctx, "SELECT MAX(level) FROM delegations")
+	if err := row.Scan(&level); err != nil {
+		return 0, err
+	}
+	if !level.Valid {
+		return 0, nil
+	}
+	return int(level.Int64), nil
+}
+
+func (s *sqliteStore) Query(ctx context.Context, filter DelegationFilter) ([]Delegation, error) {
+	query := "SELECT timestamp, amount, delegator, level FROM delegations WHERE 1 = 1"
+	var args []interface{}
+
+	if filter.Year != "" {
+		query += " AND strftime('%Y', timestamp) = ?"
+		args = append(args, filter.Year)
+	}
+	if filter.From != "" {
+		query += " AND timestamp >= ?"
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		query += " AND timestamp <= ?"
+		args = append(args, filter.To)
+	}
+	if filter.Delegator != "" {
+		query += " AND delegator = ?"
+		args = append(args, filter.Delegator)
+	}
+	if filter.MinAmount > 0 {
+		query += " AND amount >= ?"
+		args = append(args, filter.MinAmount)
+	}
+	if filter.Cursor != nil {
+		query += " AND (timestamp < ? OR (timestamp = ? AND delegator < ?))"
+		args = append(args, filter.Cursor.Timestamp, filter.Cursor.Timestamp, filter.Cursor.Delegator)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	query += " ORDER BY timestamp DESC, delegator DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var delegations []Delegation
+	for rows.Next() {
+		var d Delegation
+		if err := rows.Scan(&d.Timestamp, &d.Amount, &d.Delegator, &d.Level); err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return delegations, nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// applyPoolConfig applique les réglages de pool de connexions, comme appris
+// à nos dépens avec discosrv : sans cap explicite, PostgreSQL se retrouve
+// vite saturé de connexions, et SQLite n'en tolère qu'une poignée en
+// écriture concurrente.
+func applyPoolConfig(db *sql.DB, pool PoolConfig) {
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+}
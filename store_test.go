@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	store, err := newSQLiteStore(":memory:", PoolConfig{}, "")
+	assert.NoError(t, err, "Error initializing SQLite store")
+	defer store.Close()
+
+	ctx := context.Background()
+
+	ts, err := store.LastTimestamp(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultStartTimestamp, ts, "An empty store should report the default start timestamp")
+
+	level, err := store.LastLevel(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, level, "An empty store should report level 0")
+
+	_, err = store.InsertDelegations(ctx, []Delegation{
+		{Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "tz1TestAddress", Level: 123456},
+	})
+	assert.NoError(t, err, "Error inserting test record")
+
+	ts, err = store.LastTimestamp(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "2023-01-01T00:00:00Z", ts, "Timestamp should match the latest record")
+
+	level, err = store.LastLevel(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 123456, level, "Level should match the latest record")
+
+	delegations, err := store.Query(ctx, DelegationFilter{Year: "2023"})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 1)
+
+	delegations, err = store.Query(ctx, DelegationFilter{Year: "2024"})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 0, "A non-matching year should return no rows")
+
+	// Inserting the same (timestamp, delegator) pair again must be a no-op,
+	// and InsertDelegations must report it as such.
+	inserted, err := store.InsertDelegations(ctx, []Delegation{
+		{Timestamp: "2023-01-01T00:00:00Z", Amount: 2000, Delegator: "tz1TestAddress", Level: 123457},
+		{Timestamp: "2023-01-02T00:00:00Z", Amount: 3000, Delegator: "tz1OtherAddress", Level: 123458},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inserted, "only the non-duplicate row should be counted as inserted")
+	delegations, err = store.Query(ctx, DelegationFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 2, "the duplicate (timestamp, delegator) row should be ignored, the new one kept")
+}
+
+// TestPostgresStore exerce le backend PostgreSQL contre une vraie instance.
+// Gated on POSTGRES_TEST_DSN pour ne pas requérir PostgreSQL en CI par défaut.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set, skipping PostgreSQL integration test")
+	}
+
+	store, err := newPostgresStore(dsn, PoolConfig{MaxOpenConns: 5, MaxIdleConns: 2}, "")
+	assert.NoError(t, err, "Error initializing PostgreSQL store")
+	defer store.Close()
+
+	ctx := context.Background()
+
+	_, err = store.InsertDelegations(ctx, []Delegation{
+		{Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "tz1PgTestAddress", Level: 123456},
+	})
+	assert.NoError(t, err, "Error inserting test record")
+
+	delegations, err := store.Query(ctx, DelegationFilter{Year: "2023"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, delegations)
+}